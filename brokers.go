@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BrokerConfig describes a single Global Broker endpoint to subscribe to,
+// with its own credentials and TLS material.
+type BrokerConfig struct {
+	Server   string `json:"server" yaml:"server"`
+	Topic    string `json:"topic" yaml:"topic"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	CAFile   string `json:"cafile" yaml:"cafile"`
+	Cert     string `json:"cert" yaml:"cert"`
+	Key      string `json:"key" yaml:"key"`
+}
+
+// multiFlag collects repeated occurrences of the same command line flag,
+// e.g. -server a -server b, into an ordered slice.
+type multiFlag []string
+
+func (m *multiFlag) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *multiFlag) Set(value string) error {
+	*m = append(*m, value)
+	return nil
+}
+
+// loadBrokerConfigsFromFile reads a list of broker configurations from a
+// YAML or JSON file, selected by file extension.
+func loadBrokerConfigsFromFile(path string) ([]BrokerConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading broker config file: %v", err)
+	}
+
+	var wrapper struct {
+		Brokers []BrokerConfig `json:"brokers" yaml:"brokers"`
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &wrapper); err != nil {
+			return nil, fmt.Errorf("error parsing broker config YAML: %v", err)
+		}
+	default:
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return nil, fmt.Errorf("error parsing broker config JSON: %v", err)
+		}
+	}
+
+	if len(wrapper.Brokers) == 0 {
+		return nil, fmt.Errorf("broker config file %s defines no brokers", path)
+	}
+	return wrapper.Brokers, nil
+}
+
+// brokerConfigsFromFlags zips together the repeated -server/-topic/... flags
+// into one BrokerConfig per broker. A single -username, -password, -cafile,
+// -cert or -key applies to every broker; a single -topic likewise applies to
+// every broker if only one was given. Otherwise each repeatable flag must be
+// given once per -server.
+func brokerConfigsFromFlags(servers, topics, usernames, passwords, caFiles, certs, keys multiFlag) ([]BrokerConfig, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("at least one -server is required")
+	}
+
+	pick := func(values multiFlag, i int, flagName string) (string, error) {
+		switch {
+		case len(values) == 0:
+			return "", nil
+		case len(values) == 1:
+			return values[0], nil
+		case len(values) == len(servers):
+			return values[i], nil
+		default:
+			return "", fmt.Errorf("-%s must be given once, or once per -server", flagName)
+		}
+	}
+
+	configs := make([]BrokerConfig, len(servers))
+	for i, server := range servers {
+		topic, err := pick(topics, i, "topic")
+		if err != nil {
+			return nil, err
+		}
+		username, err := pick(usernames, i, "username")
+		if err != nil {
+			return nil, err
+		}
+		password, err := pick(passwords, i, "password")
+		if err != nil {
+			return nil, err
+		}
+		caFile, err := pick(caFiles, i, "cafile")
+		if err != nil {
+			return nil, err
+		}
+		cert, err := pick(certs, i, "cert")
+		if err != nil {
+			return nil, err
+		}
+		key, err := pick(keys, i, "key")
+		if err != nil {
+			return nil, err
+		}
+
+		if topic == "" {
+			return nil, fmt.Errorf("broker %s has no -topic", server)
+		}
+
+		configs[i] = BrokerConfig{
+			Server:   server,
+			Topic:    topic,
+			Username: username,
+			Password: password,
+			CAFile:   caFile,
+			Cert:     cert,
+			Key:      key,
+		}
+	}
+	return configs, nil
+}
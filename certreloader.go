@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// certReloadFallback is how often certReloader re-reads its files even if
+// fsnotify did not report a change, to cover watchers missing events across
+// some mounted filesystems (NFS, some container overlays).
+const certReloadFallback = 5 * time.Minute
+
+// certReloader watches a CA bundle and an optional client certificate/key
+// pair on disk and rebuilds the corresponding *x509.CertPool and
+// tls.Certificate whenever they change, so that long-running connections can
+// pick up rotated TLS material without a process restart.
+type certReloader struct {
+	caFile, certFile, keyFile string
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+	cert *tls.Certificate
+}
+
+// newCertReloader loads the initial TLS material and starts a background
+// watcher. caFile, certFile and keyFile may each be empty.
+func newCertReloader(caFile, certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{caFile: caFile, certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		p, err := loadCertPool(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = p
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" && r.keyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	r.mu.Lock()
+	r.pool = pool
+	r.cert = cert
+	r.mu.Unlock()
+	return nil
+}
+
+// CertPool returns the current CA pool, or nil if no cafile was configured.
+func (r *certReloader) CertPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// GetClientCertificate is suitable for tls.Config.GetClientCertificate: it
+// always hands back the most recently loaded client certificate.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+// watch reloads the certificate material whenever fsnotify reports a change
+// in one of the watched directories, and on a periodic fallback timer in
+// case events are missed.
+//
+// It watches the *containing directories* rather than the files themselves,
+// and reacts to any write/create/rename event in them rather than requiring
+// an exact filename match. Cert-rotation tools replace files by renaming a
+// new inode into place, which drops a watch held on the old inode, so
+// watching the directory is what survives an atomic replace without needing
+// to re-Add anything. The exact-filename match this used to require is not
+// enough on its own: Kubernetes Secret volume mounts rotate via an atomic
+// swap of a "..data" symlink, so the event fsnotify reports never names
+// caFile/certFile/keyFile directly. Reloading on any directory event (reload
+// itself is cheap and idempotent) covers that case too.
+func (r *certReloader) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Error starting certificate watcher, falling back to periodic reload only: %v", err)
+		r.pollLoop()
+		return
+	}
+	defer watcher.Close()
+
+	watched := map[string]bool{}
+	for _, f := range []string{r.caFile, r.certFile, r.keyFile} {
+		if f == "" {
+			continue
+		}
+		dir := filepath.Dir(f)
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("Error watching %s for changes: %v", dir, err)
+			continue
+		}
+		watched[dir] = true
+	}
+
+	ticker := time.NewTicker(certReloadFallback)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				log.Printf("Error reloading TLS material after change to %s: %v", event.Name, err)
+				continue
+			}
+			log.Printf("Reloaded TLS material after change to %s", event.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Certificate watcher error: %v", err)
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				log.Printf("Error reloading TLS material on periodic check: %v", err)
+			}
+		}
+	}
+}
+
+// pollLoop is the fallback used when the fsnotify watcher itself could not
+// be created.
+func (r *certReloader) pollLoop() {
+	ticker := time.NewTicker(certReloadFallback)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := r.reload(); err != nil {
+			log.Printf("Error reloading TLS material on periodic check: %v", err)
+		}
+	}
+}
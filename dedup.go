@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// dedupEntry records when a notification was last observed.
+type dedupEntry struct {
+	LastSeen time.Time
+}
+
+var (
+	seenNotifications sync.Map // map[string]dedupEntry
+
+	dedupTTL     *time.Duration
+	dedupDisable *bool
+)
+
+// notificationIdentity holds the subset of a WIS2 Notification Message that
+// identifies it, independent of relaying: brokers commonly re-serialise a
+// message (field order, added broker metadata) without changing its id or
+// data_id, so keying on these fields catches redeliveries that a hash of the
+// raw payload would miss.
+type notificationIdentity struct {
+	ID         string `json:"id"`
+	DataID     string `json:"data_id"`
+	Properties struct {
+		Pubtime string `json:"pubtime"`
+	} `json:"properties"`
+}
+
+// notificationKey derives a stable identifier for a notification payload so
+// that redeliveries from the same or different brokers can be recognised as
+// duplicates. It keys on the notification's id (or data_id) plus pubtime,
+// which survive re-serialisation by relaying brokers; if the payload carries
+// neither, it falls back to hashing the raw payload.
+func notificationKey(payload []byte) string {
+	var id notificationIdentity
+	if err := json.Unmarshal(payload, &id); err == nil {
+		key := id.ID
+		if key == "" {
+			key = id.DataID
+		}
+		if key != "" {
+			sum := sha256.Sum256([]byte(key + "|" + id.Properties.Pubtime))
+			return hex.EncodeToString(sum[:])
+		}
+	}
+
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// isDuplicate reports whether key has been seen within the last ttl and, if
+// not, records it as seen now.
+func isDuplicate(key string, ttl time.Duration) bool {
+	now := time.Now()
+
+	if v, ok := seenNotifications.Load(key); ok {
+		entry := v.(dedupEntry)
+		if now.Sub(entry.LastSeen) < ttl {
+			return true
+		}
+	}
+
+	seenNotifications.Store(key, dedupEntry{LastSeen: now})
+	return false
+}
+
+// sweepDedupCache periodically removes entries older than ttl so the cache
+// does not grow without bound over a long-running process.
+func sweepDedupCache(ttl time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			seenNotifications.Range(func(key, value interface{}) bool {
+				entry := value.(dedupEntry)
+				if now.Sub(entry.LastSeen) >= ttl {
+					seenNotifications.Delete(key)
+				}
+				return true
+			})
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotificationKeySameAcrossReserialisation(t *testing.T) {
+	a := []byte(`{"id":"urn:wis2:123","properties":{"pubtime":"2026-07-26T00:00:00Z","content":{"size":4}}}`)
+	b := []byte(`{"properties":{"content":{"size":4},"pubtime":"2026-07-26T00:00:00Z"},"id":"urn:wis2:123","broker":"relay-2"}`)
+
+	if notificationKey(a) != notificationKey(b) {
+		t.Error("expected the same id/pubtime to produce the same key despite different field order and added broker metadata")
+	}
+}
+
+func TestNotificationKeyDiffersOnDataID(t *testing.T) {
+	a := []byte(`{"data_id":"one","properties":{"pubtime":"2026-07-26T00:00:00Z"}}`)
+	b := []byte(`{"data_id":"two","properties":{"pubtime":"2026-07-26T00:00:00Z"}}`)
+
+	if notificationKey(a) == notificationKey(b) {
+		t.Error("expected different data_id values to produce different keys")
+	}
+}
+
+func TestNotificationKeyFallsBackToRawPayload(t *testing.T) {
+	a := []byte(`{"no_id_or_data_id":true}`)
+	b := []byte(`{"no_id_or_data_id":false}`)
+
+	if notificationKey(a) == notificationKey(b) {
+		t.Error("expected payloads with no id/data_id to fall back to distinguishable raw-payload keys")
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	key := "some-key"
+	ttl := time.Hour
+
+	if isDuplicate(key, ttl) {
+		t.Fatal("first observation of a key should not be reported as a duplicate")
+	}
+	if !isDuplicate(key, ttl) {
+		t.Fatal("second observation within the TTL should be reported as a duplicate")
+	}
+
+	seenNotifications.Delete(key)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// queuePolicy controls what happens when a downloader's job queue is full.
+type queuePolicy string
+
+const (
+	queuePolicyDropNew    queuePolicy = "drop-new"
+	queuePolicyDropOldest queuePolicy = "drop-oldest"
+)
+
+// hostRetryDelay is how long a job waits before being requeued after its
+// host's semaphore was full, so that a saturated host doesn't spin a worker.
+const hostRetryDelay = 50 * time.Millisecond
+
+// downloadJob is one queued download, carrying everything downloadWithRetry
+// needs.
+type downloadJob struct {
+	url             string
+	dir             string
+	integrityMethod string
+	integrityValue  string
+	expectedSize    int64
+}
+
+// downloader is a fixed-size worker pool with a bounded job queue and a
+// per-origin-host semaphore, so that a storm of notifications cannot spawn
+// unbounded goroutines and a single slow origin cannot starve downloads from
+// other origins.
+type downloader struct {
+	jobs         chan downloadJob
+	policy       queuePolicy
+	perHostLimit int
+
+	mu      sync.Mutex
+	hostSem map[string]chan struct{}
+}
+
+// newDownloader starts workers goroutines consuming from a queue of size
+// queueSize, and returns the downloader handle used to submit jobs to them.
+func newDownloader(workers, queueSize int, policy queuePolicy, perHostLimit int) *downloader {
+	d := &downloader{
+		jobs:         make(chan downloadJob, queueSize),
+		policy:       policy,
+		perHostLimit: perHostLimit,
+		hostSem:      make(map[string]chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+	return d
+}
+
+func (d *downloader) worker() {
+	for job := range d.jobs {
+		sem := d.hostSemaphore(hostOf(job.url))
+		select {
+		case sem <- struct{}{}:
+			d.run(job, sem)
+		default:
+			// This host is already at its concurrency limit. Don't block this
+			// worker on it — requeue the job and let the worker pick up
+			// something else in the meantime.
+			d.requeue(job)
+		}
+	}
+}
+
+// hostSemaphore returns the semaphore used to bound concurrent downloads
+// from host, creating it on first use.
+func (d *downloader) hostSemaphore(host string) chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sem, ok := d.hostSem[host]
+	if !ok {
+		sem = make(chan struct{}, d.perHostLimit)
+		d.hostSem[host] = sem
+	}
+	return sem
+}
+
+// requeue resubmits job after a short delay, off the calling worker, so a
+// saturated host's jobs don't spin a worker in a tight retry loop.
+func (d *downloader) requeue(job downloadJob) {
+	go func() {
+		time.Sleep(hostRetryDelay)
+		d.Submit(job)
+	}()
+}
+
+func (d *downloader) run(job downloadJob, sem chan struct{}) {
+	defer func() { <-sem }()
+
+	start := time.Now()
+	written, err := downloadWithRetry(job.url, job.dir, job.integrityMethod, job.integrityValue, job.expectedSize)
+	recordDownloadResult(written, time.Since(start), err)
+	if err != nil {
+		log.Printf("Error downloading file from %s: %v", job.url, err)
+	}
+}
+
+// Submit enqueues job, applying the configured queue policy if the queue is
+// full: drop-new discards job itself, drop-oldest discards the
+// longest-queued job to make room for it.
+func (d *downloader) Submit(job downloadJob) {
+	select {
+	case d.jobs <- job:
+		return
+	default:
+	}
+
+	if d.policy == queuePolicyDropOldest {
+		select {
+		case <-d.jobs:
+		default:
+		}
+		select {
+		case d.jobs <- job:
+			return
+		default:
+		}
+	}
+
+	log.Printf("Download queue full; dropping job for %s", job.url)
+}
+
+// QueueDepth reports how many jobs are currently queued, for the
+// wis2_queue_depth metric.
+func (d *downloader) QueueDepth() int {
+	return len(d.jobs)
+}
+
+// hostOf extracts the host (including port) from url, falling back to the
+// whole URL if it cannot be parsed so callers still get some isolation.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
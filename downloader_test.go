@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHostOf(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com:8443/path/file.txt", "example.com:8443"},
+		{"http://example.org/file.grib2", "example.org"},
+		{"not a url", "not a url"},
+	}
+
+	for _, tt := range tests {
+		if got := hostOf(tt.url); got != tt.want {
+			t.Errorf("hostOf(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestDownloaderSubmitDropsOldestWhenFull(t *testing.T) {
+	d := &downloader{
+		jobs:    make(chan downloadJob, 1),
+		policy:  queuePolicyDropOldest,
+		hostSem: make(map[string]chan struct{}),
+	}
+
+	d.jobs <- downloadJob{url: "http://a/1"}
+	d.Submit(downloadJob{url: "http://a/2"})
+
+	got := <-d.jobs
+	if got.url != "http://a/2" {
+		t.Errorf("expected the newest job to survive a drop-oldest submit, got %q", got.url)
+	}
+}
+
+func TestDownloaderSubmitDropsNewWhenFull(t *testing.T) {
+	d := &downloader{
+		jobs:    make(chan downloadJob, 1),
+		policy:  queuePolicyDropNew,
+		hostSem: make(map[string]chan struct{}),
+	}
+
+	d.jobs <- downloadJob{url: "http://a/1"}
+	d.Submit(downloadJob{url: "http://a/2"})
+
+	got := <-d.jobs
+	if got.url != "http://a/1" {
+		t.Errorf("expected the original job to survive a drop-new submit, got %q", got.url)
+	}
+}
+
+func TestDownloaderHostSemaphoreIsPerHost(t *testing.T) {
+	d := &downloader{perHostLimit: 1, hostSem: make(map[string]chan struct{})}
+
+	a1 := d.hostSemaphore("host-a")
+	a2 := d.hostSemaphore("host-a")
+	b := d.hostSemaphore("host-b")
+
+	if a1 != a2 {
+		t.Error("expected the same semaphore to be returned for the same host")
+	}
+	if a1 == b {
+		t.Error("expected different hosts to get independent semaphores")
+	}
+	if cap(a1) != 1 {
+		t.Errorf("cap(a1) = %d, want 1", cap(a1))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// newIntegrityHash returns a hash.Hash for the method declared in a
+// notification's integrity block (sha256, sha384 or sha512), as used by the
+// WIS2 Notification Message format.
+func newIntegrityHash(method string) (hash.Hash, error) {
+	switch strings.ToLower(method) {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha384":
+		return sha512.New384(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported integrity method: %s", method)
+	}
+}
+
+// decodeIntegrityValue decodes the declared digest value, which WIS2
+// notifications may encode as base64 or as hex. Hex is tried first: its
+// alphabet is a strict subset of base64's, so a hex string (e.g. a 64-char
+// sha256 digest) would otherwise also decode "successfully" as base64,
+// silently producing the wrong bytes.
+func decodeIntegrityValue(value string) ([]byte, error) {
+	if isHexString(value) {
+		if decoded, err := hex.DecodeString(value); err == nil {
+			return decoded, nil
+		}
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return decoded, nil
+	}
+	if decoded, err := hex.DecodeString(value); err == nil {
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("integrity value is neither valid base64 nor hex: %s", value)
+}
+
+// isHexString reports whether value looks like a hex-encoded digest: an
+// even number of hex digits and nothing else.
+func isHexString(value string) bool {
+	if len(value) == 0 || len(value)%2 != 0 {
+		return false
+	}
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9', r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDecodeIntegrityValue(t *testing.T) {
+	sum := sha256.Sum256([]byte("wis2downloader"))
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"hex", hex.EncodeToString(sum[:])},
+		{"uppercase hex", strings.ToUpper(hex.EncodeToString(sum[:]))},
+		{"base64", base64.StdEncoding.EncodeToString(sum[:])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := decodeIntegrityValue(tt.value)
+			if err != nil {
+				t.Fatalf("decodeIntegrityValue(%q) returned error: %v", tt.value, err)
+			}
+			if !bytes.Equal(decoded, sum[:]) {
+				t.Fatalf("decodeIntegrityValue(%q) = %x, want %x", tt.value, decoded, sum[:])
+			}
+		})
+	}
+}
+
+func TestDecodeIntegrityValueInvalid(t *testing.T) {
+	if _, err := decodeIntegrityValue("not-valid-!!!"); err == nil {
+		t.Fatal("expected an error for a value that is neither hex nor base64")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtRefreshSkew is how long before a token's exp a broker connection is
+// forced to reconnect, so the session never runs on an expired token.
+const jwtRefreshSkew = time.Minute
+
+// authConfig carries the --auth-mode flag and its accompanying --jwt-*
+// settings through to each broker client.
+type authConfig struct {
+	mode         string
+	jwtKey       string
+	jwtAlgorithm string
+	jwtAudience  string
+	jwtTTL       time.Duration
+}
+
+// jwtIssuer mints short-lived signed JWTs to use as an MQTT password and
+// arranges for the connection to be refreshed shortly before each token
+// expires.
+type jwtIssuer struct {
+	key      interface{} // *rsa.PrivateKey or *ecdsa.PrivateKey
+	method   jwt.SigningMethod
+	audience string
+	subject  string
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// newJWTIssuer loads a PEM-encoded signing key and builds an issuer for the
+// given algorithm (RS256 or ES256).
+func newJWTIssuer(keyPath, algorithm, audience, subject string, ttl time.Duration) (*jwtIssuer, error) {
+	pemBytes, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading JWT signing key: %v", err)
+	}
+
+	var key interface{}
+	var method jwt.SigningMethod
+	switch strings.ToUpper(algorithm) {
+	case "RS256":
+		key, err = jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		method = jwt.SigningMethodRS256
+	case "ES256":
+		key, err = jwt.ParseECPrivateKeyFromPEM(pemBytes)
+		method = jwt.SigningMethodES256
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JWT signing key: %v", err)
+	}
+
+	return &jwtIssuer{key: key, method: method, audience: audience, subject: subject, ttl: ttl}, nil
+}
+
+// issue mints a new token and returns it along with its expiry time.
+func (j *jwtIssuer) issue() (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(j.ttl)
+
+	claims := jwt.MapClaims{
+		"iat": now.Unix(),
+		"exp": exp.Unix(),
+	}
+	if j.audience != "" {
+		claims["aud"] = j.audience
+	}
+	if j.subject != "" {
+		claims["sub"] = j.subject
+	}
+
+	signed, err := jwt.NewWithClaims(j.method, claims).SignedString(j.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("error signing JWT: %v", err)
+	}
+	return signed, exp, nil
+}
+
+// scheduleRefresh arranges for reconnect to be called shortly before exp,
+// replacing any previously scheduled refresh for this issuer.
+func (j *jwtIssuer) scheduleRefresh(exp time.Time, reconnect func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+
+	wait := time.Until(exp) - jwtRefreshSkew
+	if wait < 0 {
+		wait = 0
+	}
+	j.timer = time.AfterFunc(wait, reconnect)
+}
+
+// credentialsProvider returns an mqtt.CredentialsProvider that mints a fresh
+// JWT on every (re)connect and keeps it refreshed before it expires.
+func (j *jwtIssuer) credentialsProvider(username, server string, client *mqtt.Client) mqtt.CredentialsProvider {
+	return func() (string, string) {
+		token, exp, err := j.issue()
+		if err != nil {
+			log.Printf("Error issuing JWT for %s: %v", server, err)
+			return username, ""
+		}
+
+		j.scheduleRefresh(exp, func() {
+			log.Printf("Refreshing MQTT session for %s before JWT expiry", server)
+			(*client).Disconnect(250)
+			connectToBroker(*client, server)
+		})
+
+		return username, token
+	}
+}
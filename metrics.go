@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	messagesReceivedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wis2_messages_received_total",
+		Help: "Total number of WIS2 notification messages received from MQTT brokers.",
+	})
+
+	downloadsSuccessTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wis2_downloads_success_total",
+		Help: "Total number of files downloaded successfully.",
+	})
+
+	downloadsFailureTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wis2_downloads_failure_total",
+		Help: "Total number of downloads that failed after exhausting retries.",
+	})
+
+	downloadBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wis2_download_bytes_total",
+		Help: "Total number of bytes successfully downloaded.",
+	})
+
+	downloadDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wis2_download_duration_seconds",
+		Help:    "Time taken to download a file, including retries.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	dedupHitsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "wis2_dedup_hits_total",
+		Help: "Total number of notifications suppressed as duplicates.",
+	})
+
+	mqttConnectedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "wis2_mqtt_connected",
+		Help: "Whether the downloader is currently connected to a broker (1) or not (0).",
+	}, []string{"broker"})
+)
+
+// recordDownloadResult updates the download counters and duration histogram
+// for a single (possibly retried) download attempt.
+func recordDownloadResult(size int64, duration time.Duration, err error) {
+	downloadDurationSeconds.Observe(duration.Seconds())
+	if err != nil {
+		downloadsFailureTotal.Inc()
+		return
+	}
+	downloadsSuccessTotal.Inc()
+	downloadBytesTotal.Add(float64(size))
+}
+
+// initMetrics registers the queue-depth gauge against d and, if addr is
+// non-empty, serves the Prometheus exposition format on addr.
+func initMetrics(addr string, d *downloader) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "wis2_queue_depth",
+		Help: "Current number of download jobs waiting in the queue.",
+	}, func() float64 {
+		return float64(d.QueueDepth())
+	})
+
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatalf("Error serving metrics on %s: %v", addr, err)
+		}
+	}()
+	log.Printf("Serving Prometheus metrics on %s/metrics", addr)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// buildTLSConfig assembles the TLS config used to connect to a single
+// broker. RootCAs and the client certificate are backed by reloader, which
+// keeps them current as the underlying files rotate.
+func buildTLSConfig(reloader *certReloader, insecure bool) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify:   insecure,
+		RootCAs:              reloader.CertPool(),
+		GetClientCertificate: reloader.GetClientCertificate,
+	}
+}
+
+// newBrokerClient builds an mqtt.Client for a single broker, subscribing to
+// its topic and dispatching downloads to downloadDir once connected.
+//
+// The client is configured with an OnConnectAttempt handler (paho PR #497)
+// so that the TLS config presented on every (re)connection attempt reflects
+// whatever certReloader currently holds, rather than what was loaded at
+// startup.
+func newBrokerClient(cfg BrokerConfig, clientID, downloadDir string, insecure bool, auth authConfig) (mqtt.Client, error) {
+	reloader, err := newCertReloader(cfg.CAFile, cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions().AddBroker(cfg.Server)
+	opts.SetClientID(clientID)
+	opts.SetAutoReconnect(true)
+	opts.SetConnectRetry(true)
+	opts.SetConnectRetryInterval(2 * time.Second)
+	opts.SetMaxReconnectInterval(1 * time.Minute)
+	opts.SetKeepAlive(60 * time.Second)
+	opts.SetPingTimeout(30 * time.Second)
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		mqttConnectedGauge.WithLabelValues(cfg.Server).Set(0)
+		log.Printf("Connection lost to %s: %v", cfg.Server, err)
+	})
+	opts.SetTLSConfig(buildTLSConfig(reloader, insecure))
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		return buildTLSConfig(reloader, insecure)
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Printf("Connected to MQTT broker %s", cfg.Server)
+		mqttConnectedGauge.WithLabelValues(cfg.Server).Set(1)
+		messageHandler := createMessageHandler(downloadDir)
+		if token := client.Subscribe(cfg.Topic, 0, messageHandler); token.Wait() && token.Error() != nil {
+			log.Fatal(token.Error())
+		}
+		fmt.Printf("Subscribed to topic %q on %s\n", cfg.Topic, cfg.Server)
+	})
+
+	var client mqtt.Client
+	if auth.mode == "jwt" {
+		issuer, err := newJWTIssuer(auth.jwtKey, auth.jwtAlgorithm, auth.jwtAudience, clientID, auth.jwtTTL)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetCredentialsProvider(issuer.credentialsProvider(cfg.Username, cfg.Server, &client))
+	} else {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+
+	client = mqtt.NewClient(opts)
+	return client, nil
+}
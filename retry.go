@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// httpStatusError is returned by downloadFile when the origin responds with
+// a non-200 status, so that retry logic can inspect the status code and
+// honour Retry-After without parsing error strings.
+type httpStatusError struct {
+	Code       int
+	Status     string
+	RetryAfter string
+}
+
+func (e *httpStatusError) Error() string {
+	return "bad status: " + e.Status
+}
+
+// integrityError is returned by downloadFile when the downloaded payload
+// fails the declared size or checksum check.
+type integrityError struct {
+	msg string
+}
+
+func (e *integrityError) Error() string {
+	return e.msg
+}
+
+// isRetryable reports whether a download error is worth retrying: network
+// errors, 5xx, 408, 429, and integrity-verification failures. Other 4xx
+// responses are treated as permanent.
+func isRetryable(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+		return statusErr.Code >= 500
+	}
+
+	var integErr *integrityError
+	if errors.As(err, &integErr) {
+		return true
+	}
+
+	// Anything else (DNS failures, connection refused/reset, TLS errors, ...)
+	// comes straight from the http.Client and is assumed to be transient.
+	return true
+}
+
+// retryAfterDelay parses a Retry-After header value (seconds form only,
+// which is what the brokers and origins this downloader talks to use) and
+// reports whether it could be parsed.
+func retryAfterDelay(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// withJitter adds up to 20% jitter to d.
+func withJitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+// nextBackoff doubles sleep, capped at max, for use as the following
+// attempt's base backoff.
+func nextBackoff(sleep, max time.Duration) time.Duration {
+	next := sleep * 2
+	if next > max {
+		next = max
+	}
+	return next
+}
+
+// downloadWithRetry wraps downloadFile with exponential backoff, bounded by
+// --download-retries attempts and an overall --download-retry-timeout
+// budget. The budget also bounds each individual attempt, via a context
+// deadline passed into downloadFile, so a single hung or slow-trickle
+// connection cannot run past it. It retries network errors, 5xx, 408, 429
+// (honouring Retry-After), and integrity-verification failures; other 4xx
+// errors are returned immediately.
+func downloadWithRetry(url, dir, integrityMethod, integrityValue string, expectedSize int64) (int64, error) {
+	deadline := time.Now().Add(*downloadRetryTimeout)
+	sleep := *downloadSleep
+
+	var lastErr error
+	var lastWritten int64
+	start := time.Now()
+	for attempt := 1; attempt <= *downloadRetries; attempt++ {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			log.Printf("Download retry budget exceeded for %s before attempt %d (elapsed %s): %v", url, attempt, time.Since(start), lastErr)
+			return lastWritten, lastErr
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), remaining)
+		written, err := downloadFile(ctx, url, dir, integrityMethod, integrityValue, expectedSize)
+		cancel()
+		if err == nil {
+			return written, nil
+		}
+		lastErr = err
+		lastWritten = written
+
+		if !isRetryable(err) {
+			return written, err
+		}
+		if attempt == *downloadRetries {
+			break
+		}
+
+		wait := withJitter(sleep)
+		sleep = nextBackoff(sleep, *downloadMaxSleep)
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) {
+			if d, ok := retryAfterDelay(statusErr.RetryAfter); ok {
+				wait = d
+			}
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			log.Printf("Download retry budget exceeded for %s after attempt %d (elapsed %s): %v", url, attempt, time.Since(start), err)
+			return written, err
+		}
+
+		log.Printf("Download attempt %d for %s failed (elapsed %s): %v; retrying in %s", attempt, url, time.Since(start), err, wait)
+		time.Sleep(wait)
+	}
+
+	return lastWritten, lastErr
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"5xx", &httpStatusError{Code: http.StatusBadGateway}, true},
+		{"408", &httpStatusError{Code: http.StatusRequestTimeout}, true},
+		{"429", &httpStatusError{Code: http.StatusTooManyRequests}, true},
+		{"404", &httpStatusError{Code: http.StatusNotFound}, false},
+		{"403", &httpStatusError{Code: http.StatusForbidden}, false},
+		{"integrity mismatch", &integrityError{msg: "bad digest"}, true},
+		{"network error", errors.New("connection reset by peer"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextBackoffCapsAtMax(t *testing.T) {
+	max := 10 * time.Second
+	if got := nextBackoff(8*time.Second, max); got != max {
+		t.Errorf("nextBackoff(8s, 10s) = %v, want %v", got, max)
+	}
+	if got := nextBackoff(2*time.Second, max); got != 4*time.Second {
+		t.Errorf("nextBackoff(2s, 10s) = %v, want 4s", got)
+	}
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	if d, ok := retryAfterDelay("5"); !ok || d != 5*time.Second {
+		t.Errorf("retryAfterDelay(\"5\") = %v, %v, want 5s, true", d, ok)
+	}
+	if _, ok := retryAfterDelay(""); ok {
+		t.Error("retryAfterDelay(\"\") should not parse")
+	}
+	if _, ok := retryAfterDelay("not-a-number"); ok {
+		t.Error("retryAfterDelay(\"not-a-number\") should not parse")
+	}
+	if _, ok := retryAfterDelay("-1"); ok {
+		t.Error("retryAfterDelay(\"-1\") should not parse")
+	}
+}
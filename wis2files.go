@@ -1,11 +1,13 @@
 package main
 
 import (
-	"crypto/tls"
+	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
@@ -14,7 +16,6 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -26,78 +27,126 @@ type NotificationMessage struct {
 		Type string `json:"type"`
 		Rel  string `json:"rel"` // Added rel field
 	} `json:"links"`
+	Properties struct {
+		Integrity struct {
+			Method string `json:"method"`
+			Value  string `json:"value"`
+		} `json:"integrity"`
+		Content struct {
+			Size int64 `json:"size"`
+		} `json:"content"`
+	} `json:"properties"`
 }
 
 var (
 	// Global variables to hold command line arguments for easy access
-	server      *string
-	topic       *string
-	downloadDir *string
-	client      mqtt.Client
+	downloadDir       *string
+	integrityRequired *bool
+
+	downloadRetries      *int
+	downloadSleep        *time.Duration
+	downloadMaxSleep     *time.Duration
+	downloadRetryTimeout *time.Duration
+
+	jobDownloader *downloader
 )
 
 func main() {
-	server = flag.String("server", "", "MQTT server address (e.g., ssl://example.com:8883)")
-	topic = flag.String("topic", "", "MQTT topic to subscribe")
-	username := flag.String("username", "", "MQTT username")
-	password := flag.String("password", "", "MQTT password")
-	caFile := flag.String("cafile", "", "Path to CA certificate file")
-	clientCert := flag.String("cert", "", "Path to client certificate file")
-	clientKey := flag.String("key", "", "Path to client key file")
+	var servers, topics, usernames, passwords, caFiles, certs, keys multiFlag
+	flag.Var(&servers, "server", "MQTT server address (e.g., ssl://example.com:8883); repeat for multiple brokers")
+	flag.Var(&topics, "topic", "MQTT topic to subscribe; repeat once per -server, or once to apply to all")
+	flag.Var(&usernames, "username", "MQTT username; repeat once per -server, or once to apply to all")
+	flag.Var(&passwords, "password", "MQTT password; repeat once per -server, or once to apply to all")
+	flag.Var(&caFiles, "cafile", "Path to CA certificate file; repeat once per -server, or once to apply to all")
+	flag.Var(&certs, "cert", "Path to client certificate file; repeat once per -server, or once to apply to all")
+	flag.Var(&keys, "key", "Path to client key file; repeat once per -server, or once to apply to all")
+	configFile := flag.String("config", "", "Path to a YAML or JSON file listing brokers (overrides -server and friends)")
+	insecure := flag.Bool("insecure", false, "Disable TLS certificate verification (insecure, for testing only)")
+	authMode := flag.String("auth-mode", "password", "MQTT authentication mode: password or jwt")
+	jwtKey := flag.String("jwt-key", "", "Path to a PEM-encoded private key used to sign JWTs (required for -auth-mode jwt)")
+	jwtAlgorithm := flag.String("jwt-algorithm", "RS256", "JWT signing algorithm: RS256 or ES256")
+	jwtAudience := flag.String("jwt-audience", "", "JWT \"aud\" claim")
+	jwtTTL := flag.Duration("jwt-ttl", 10*time.Minute, "Lifetime of each minted JWT before it is refreshed")
 	downloadDir = flag.String("download", "downloads", "Directory to save downloaded files")
 	clientID := flag.String("clientid", "wis2-mqtt-subscriber", "MQTT client ID") // New flag
+	dedupTTL = flag.Duration("dedup-ttl", time.Hour, "How long to remember a notification before allowing it to be redelivered")
+	dedupDisable = flag.Bool("dedup-disable", false, "Disable notification deduplication entirely")
+	integrityRequired = flag.Bool("integrity-required", false, "Treat a missing or unsupported integrity block as a hard failure instead of a warning")
+	downloadRetries = flag.Int("download-retries", 5, "Maximum number of download attempts before giving up")
+	downloadSleep = flag.Duration("download-sleep", time.Second, "Initial backoff between download attempts, doubled on each retry")
+	downloadMaxSleep = flag.Duration("download-max-sleep", 30*time.Second, "Maximum backoff between download attempts")
+	downloadRetryTimeout = flag.Duration("download-retry-timeout", 5*time.Minute, "Total wall-clock budget for retrying a single download, across all attempts")
+	workers := flag.Int("workers", 10, "Number of concurrent download workers")
+	queueSize := flag.Int("queue-size", 1000, "Maximum number of download jobs waiting in the queue")
+	queuePolicyFlag := flag.String("queue-policy", "drop-new", "Policy applied when the download queue is full: drop-new or drop-oldest")
+	perHostConcurrency := flag.Int("per-host-concurrency", 2, "Maximum concurrent downloads from a single origin host")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve Prometheus metrics on (e.g. :9090); disabled if empty")
 	flag.Parse()
 
-	if *server == "" || *topic == "" {
-		log.Fatal("Server and topic are required")
-	}
-
-	opts := mqtt.NewClientOptions().AddBroker(*server)
-	opts.SetUsername(*username)
-	opts.SetPassword(*password)
-	opts.SetClientID(*clientID) // Use the client ID from the flag
-	opts.SetAutoReconnect(true)
-	opts.SetConnectRetry(true)
-	opts.SetConnectRetryInterval(2 * time.Second)
-	opts.SetMaxReconnectInterval(1 * time.Minute)
-	opts.SetKeepAlive(60 * time.Second)
-	opts.SetPingTimeout(30 * time.Second)
-	opts.SetOnConnectHandler(onConnect)
-	opts.SetConnectionLostHandler(connectLostHandler)
-
-	tlsConfig := &tls.Config{InsecureSkipVerify: true}
-	if *caFile != "" {
-		certpool, err := loadCertPool(*caFile)
-		if err != nil {
-			log.Fatalf("Error loading CA certificate: %v", err)
-		}
-		tlsConfig.RootCAs = certpool
+	var brokerConfigs []BrokerConfig
+	var err error
+	if *configFile != "" {
+		brokerConfigs, err = loadBrokerConfigsFromFile(*configFile)
+	} else {
+		brokerConfigs, err = brokerConfigsFromFlags(servers, topics, usernames, passwords, caFiles, certs, keys)
+	}
+	if err != nil {
+		log.Fatalf("Error resolving broker configuration: %v", err)
 	}
-	if *clientCert != "" && *clientKey != "" {
-		cert, err := tls.LoadX509KeyPair(*clientCert, *clientKey)
+
+	if *authMode != "password" && *authMode != "jwt" {
+		log.Fatalf("Unsupported -auth-mode: %s", *authMode)
+	}
+	if *authMode == "jwt" && *jwtKey == "" {
+		log.Fatal("-jwt-key is required when -auth-mode is jwt")
+	}
+	auth := authConfig{mode: *authMode, jwtKey: *jwtKey, jwtAlgorithm: *jwtAlgorithm, jwtAudience: *jwtAudience, jwtTTL: *jwtTTL}
+
+	policy := queuePolicy(*queuePolicyFlag)
+	if policy != queuePolicyDropNew && policy != queuePolicyDropOldest {
+		log.Fatalf("Unsupported -queue-policy: %s", *queuePolicyFlag)
+	}
+	jobDownloader = newDownloader(*workers, *queueSize, policy, *perHostConcurrency)
+	initMetrics(*metricsAddr, jobDownloader)
+
+	clients := make([]mqtt.Client, len(brokerConfigs))
+	for i, cfg := range brokerConfigs {
+		id := *clientID
+		if len(brokerConfigs) > 1 {
+			id = fmt.Sprintf("%s-%d", *clientID, i)
+		}
+		c, err := newBrokerClient(cfg, id, *downloadDir, *insecure, auth)
 		if err != nil {
-			log.Fatalf("Error loading client certificate and key: %v", err)
+			log.Fatalf("Error configuring broker %s: %v", cfg.Server, err)
 		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
+		clients[i] = c
+	}
+
+	for i, c := range clients {
+		connectToBroker(c, brokerConfigs[i].Server)
 	}
-	opts.SetTLSConfig(tlsConfig)
 
-	client = mqtt.NewClient(opts)
-	connectToBroker()
+	stopSweep := make(chan struct{})
+	if !*dedupDisable {
+		go sweepDedupCache(*dedupTTL, stopSweep)
+	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
 
-	client.Unsubscribe(*topic)
-	client.Disconnect(250)
+	close(stopSweep)
+	for i, c := range clients {
+		c.Unsubscribe(brokerConfigs[i].Topic)
+		c.Disconnect(250)
+	}
 	fmt.Println("Disconnected")
 }
 
-func connectToBroker() {
+func connectToBroker(client mqtt.Client, server string) {
 	for {
 		if token := client.Connect(); token.Wait() && token.Error() != nil {
-			log.Printf("Failed to connect to MQTT broker: %v. Retrying in 5 seconds...", token.Error())
+			log.Printf("Failed to connect to MQTT broker %s: %v. Retrying in 5 seconds...", server, token.Error())
 			time.Sleep(5 * time.Second)
 		} else {
 			break
@@ -105,27 +154,19 @@ func connectToBroker() {
 	}
 }
 
-func onConnect(client mqtt.Client) {
-	log.Println("Connected to MQTT Broker")
-	subscribeToTopic()
-}
-
-func subscribeToTopic() {
-	messageHandler := createMessageHandler(*downloadDir)
-	if token := client.Subscribe(*topic, 0, messageHandler); token.Wait() && token.Error() != nil {
-		log.Fatal(token.Error())
-	}
-	fmt.Printf("Subscribed to topic: %s\n", *topic)
-}
-
-func connectLostHandler(client mqtt.Client, err error) {
-	log.Printf("Connection lost: %v", err)
-}
-
 func createMessageHandler(downloadDir string) mqtt.MessageHandler {
-	var wg sync.WaitGroup
 	return func(client mqtt.Client, msg mqtt.Message) {
 		fmt.Printf("Received message on topic: %s\n", msg.Topic())
+		messagesReceivedTotal.Inc()
+
+		if !*dedupDisable {
+			key := notificationKey(msg.Payload())
+			if isDuplicate(key, *dedupTTL) {
+				log.Printf("Duplicate notification suppressed (key=%s)", key)
+				dedupHitsTotal.Inc()
+				return
+			}
+		}
 
 		var notification NotificationMessage
 		if err := json.Unmarshal(msg.Payload(), &notification); err != nil {
@@ -133,31 +174,39 @@ func createMessageHandler(downloadDir string) mqtt.MessageHandler {
 			return
 		}
 
+		integrity := notification.Properties.Integrity
+		expectedSize := notification.Properties.Content.Size
+
 		for _, link := range notification.Links {
 			if strings.EqualFold(link.Rel, "canonical") { // Check if rel is "canonical"
-				wg.Add(1)
-				go func(url string) {
-					defer wg.Done()
-					if err := downloadFile(url, downloadDir); err != nil {
-						log.Printf("Error downloading file from %s: %v", url, err)
-					}
-				}(link.Href)
+				jobDownloader.Submit(downloadJob{
+					url:             link.Href,
+					dir:             downloadDir,
+					integrityMethod: integrity.Method,
+					integrityValue:  integrity.Value,
+					expectedSize:    expectedSize,
+				})
 			}
 		}
-
-		wg.Wait()
 	}
 }
 
-func downloadFile(url, dir string) error {
-	resp, err := http.Get(url)
+// downloadFile performs a single download attempt, bounded by ctx's deadline
+// so that a hung or slow-trickle connection cannot run past the caller's
+// retry budget.
+func downloadFile(ctx context.Context, url, dir, integrityMethod, integrityValue string, expectedSize int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		return 0, &httpStatusError{Code: resp.StatusCode, Status: resp.Status, RetryAfter: resp.Header.Get("Retry-After")}
 	}
 
 	fileName := filepath.Base(url)
@@ -165,17 +214,63 @@ func downloadFile(url, dir string) error {
 
 	out, err := os.Create(filePath)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	var h hash.Hash
+	var wantSum []byte
+	if integrityMethod != "" && integrityValue != "" {
+		h, err = newIntegrityHash(integrityMethod)
+		if err != nil {
+			if *integrityRequired {
+				out.Close()
+				os.Remove(filePath)
+				return 0, err
+			}
+			log.Printf("Warning: %v; skipping integrity verification for %s", err, url)
+		} else {
+			wantSum, err = decodeIntegrityValue(integrityValue)
+			if err != nil {
+				out.Close()
+				os.Remove(filePath)
+				return 0, err
+			}
+		}
+	} else if *integrityRequired {
+		out.Close()
+		os.Remove(filePath)
+		return 0, fmt.Errorf("notification for %s has no integrity block and --integrity-required is set", url)
+	}
+
+	var writer io.Writer = out
+	if h != nil {
+		writer = io.MultiWriter(out, h)
+	}
+
+	written, err := io.Copy(writer, resp.Body)
 	if err != nil {
-		return err
+		out.Close()
+		os.Remove(filePath)
+		return written, err
+	}
+
+	if expectedSize > 0 && written != expectedSize {
+		out.Close()
+		os.Remove(filePath)
+		return written, &integrityError{msg: fmt.Sprintf("size mismatch for %s: expected %d bytes, got %d", url, expectedSize, written)}
+	}
+
+	if h != nil {
+		if gotSum := h.Sum(nil); !bytes.Equal(gotSum, wantSum) {
+			out.Close()
+			os.Remove(filePath)
+			return written, &integrityError{msg: fmt.Sprintf("integrity mismatch for %s: expected %x, got %x", url, wantSum, gotSum)}
+		}
 	}
 
 	log.Printf("File downloaded successfully: %s\n", filePath)
-	return nil
+	return written, nil
 }
 
 func loadCertPool(caFile string) (*x509.CertPool, error) {